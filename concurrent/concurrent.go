@@ -0,0 +1,172 @@
+/*
+Package concurrent provides a sharded, concurrency-safe wrapper around
+lpmap.Map. Keys are routed to one of a fixed number of shards by the high
+bits of their Hash(), and each shard is guarded independently, so readers
+and writers touching different shards never contend with each other and
+never hold a single lock across a linear-probe chain.
+*/
+package concurrent
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/sbromberger/lpmap"
+)
+
+// ConcurrentMap is a sharded map built from lpmap.Map instances.
+type ConcurrentMap[K lpmap.KeyType, V any] struct {
+	shards []shard[K, V]
+	mask   uint64
+}
+
+type shard[K lpmap.KeyType, V any] struct {
+	mu sync.RWMutex
+	m  lpmap.Map[K, V]
+}
+
+// New creates a ConcurrentMap sharded across runtime.GOMAXPROCS(0) (rounded
+// up to a power of two) shards, each an lpmap.Map created with the given
+// per-shard initial size, fill factor and options (e.g. lpmap.WithMetrics).
+func New[K lpmap.KeyType, V any](size int, fillFactor float64, opts ...lpmap.Option[K, V]) *ConcurrentMap[K, V] {
+	return NewShards[K, V](shardCount(), size, fillFactor, opts...)
+}
+
+// NewShards creates a ConcurrentMap with an explicit number of shards,
+// rounded up to a power of two so that shard selection is a cheap mask. opts
+// are applied to every shard's underlying lpmap.Map, as in New.
+func NewShards[K lpmap.KeyType, V any](shards, size int, fillFactor float64, opts ...lpmap.Option[K, V]) *ConcurrentMap[K, V] {
+	n := nextPowerOfTwo(shards)
+	cm := &ConcurrentMap[K, V]{
+		shards: make([]shard[K, V], n),
+		mask:   uint64(n - 1),
+	}
+	for i := range cm.shards {
+		cm.shards[i].m = lpmap.New[K, V](size, fillFactor, opts...)
+	}
+	return cm
+}
+
+func shardCount() int {
+	return nextPowerOfTwo(runtime.GOMAXPROCS(0))
+}
+
+func nextPowerOfTwo(n int) int {
+	if n < 1 {
+		return 1
+	}
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// shardFor returns the shard k is routed to. It uses the high bits of
+// Hash() so shard selection is independent of the low bits lpmap itself
+// probes on.
+func (c *ConcurrentMap[K, V]) shardFor(k K) *shard[K, V] {
+	idx := (k.Hash() >> 32) & c.mask
+	return &c.shards[idx]
+}
+
+// Get returns the value associated with k, if any.
+//
+// This takes the shard's exclusive lock rather than its read lock: lpmap.Map
+// is not a pure data structure under Get, since Get drives the shard's
+// incremental migration forward (migrateStep), which mutates state shared
+// across all callers. Two goroutines both holding only a read lock could
+// run migrateStep concurrently and corrupt the shard's tables.
+func (c *ConcurrentMap[K, V]) Get(k K) (V, bool) {
+	s := c.shardFor(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m.Get(k)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return *v, true
+}
+
+// Set inserts a key/value mapping into the map.
+func (c *ConcurrentMap[K, V]) Set(k K, v V) {
+	s := c.shardFor(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m.Set(k, v)
+}
+
+// Delete removes a key/value mapping (by key) and returns true if found,
+// false otherwise.
+func (c *ConcurrentMap[K, V]) Delete(k K) bool {
+	s := c.shardFor(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m.Delete(k)
+}
+
+// Size returns the number of entries across all shards.
+func (c *ConcurrentMap[K, V]) Size() int {
+	var total int
+	for i := range c.shards {
+		c.shards[i].mu.RLock()
+		total += c.shards[i].m.Size()
+		c.shards[i].mu.RUnlock()
+	}
+	return total
+}
+
+// Range calls f for every key/value pair in the map, stopping early if f
+// returns false. Shards are visited one at a time and locked only for the
+// duration of their own scan, so f does not see a consistent snapshot
+// across shard boundaries if the map is mutated concurrently.
+//
+// Each shard is taken under its exclusive lock, not its read lock: All
+// completes any incremental migration still in progress on the shard
+// before it yields, which mutates the shard's tables.
+func (c *ConcurrentMap[K, V]) Range(f func(K, V) bool) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		cont := true
+		for k, v := range s.m.All() {
+			if !f(k, v) {
+				cont = false
+				break
+			}
+		}
+		s.mu.Unlock()
+		if !cont {
+			return
+		}
+	}
+}
+
+// GetOrSet returns the existing value for k if present; otherwise it sets
+// k to v and returns v. loaded reports whether k was already present.
+func (c *ConcurrentMap[K, V]) GetOrSet(k K, v V) (actual V, loaded bool) {
+	s := c.shardFor(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.m.Get(k); ok {
+		return *existing, true
+	}
+	s.m.Set(k, v)
+	return v, false
+}
+
+// CompareAndDelete deletes k if its current value equals old, reporting
+// whether the delete happened. V is unconstrained, so the comparison uses
+// reflect.DeepEqual rather than requiring V to be comparable.
+func (c *ConcurrentMap[K, V]) CompareAndDelete(k K, old V) bool {
+	s := c.shardFor(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.m.Get(k)
+	if !ok || !reflect.DeepEqual(*existing, old) {
+		return false
+	}
+	return s.m.Delete(k)
+}
@@ -0,0 +1,193 @@
+package concurrent
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/sbromberger/lpmap"
+)
+
+type myKey uint32
+
+func (k myKey) Hash() uint64 {
+	return uint64(k) * 2654435761
+}
+
+func TestGetSetDelete(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5)
+
+	for i := 0; i < 1000; i++ {
+		cm.Set(myKey(i), uint64(i))
+	}
+	if cm.Size() != 1000 {
+		t.Fatalf("Size() = %d; want 1000", cm.Size())
+	}
+
+	for i := 0; i < 1000; i++ {
+		v, ok := cm.Get(myKey(i))
+		if !ok || v != uint64(i) {
+			t.Errorf("Get(%d) = (%d, %v); want (%d, true)", i, v, ok, i)
+		}
+	}
+
+	for i := 0; i < 1000; i += 2 {
+		if !cm.Delete(myKey(i)) {
+			t.Errorf("Delete(%d) = false; want true", i)
+		}
+	}
+	if cm.Size() != 500 {
+		t.Fatalf("Size() after deletes = %d; want 500", cm.Size())
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5)
+
+	v, loaded := cm.GetOrSet(myKey(1), 10)
+	if loaded || v != 10 {
+		t.Fatalf("GetOrSet first call = (%d, %v); want (10, false)", v, loaded)
+	}
+
+	v, loaded = cm.GetOrSet(myKey(1), 20)
+	if !loaded || v != 10 {
+		t.Fatalf("GetOrSet second call = (%d, %v); want (10, true)", v, loaded)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5)
+	cm.Set(myKey(1), 42)
+
+	if cm.CompareAndDelete(myKey(1), 41) {
+		t.Fatalf("CompareAndDelete succeeded with mismatched value")
+	}
+	if !cm.CompareAndDelete(myKey(1), 42) {
+		t.Fatalf("CompareAndDelete failed with matching value")
+	}
+	if _, ok := cm.Get(myKey(1)); ok {
+		t.Fatalf("key still present after CompareAndDelete")
+	}
+}
+
+func TestOptionsPlumbedToShards(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5, lpmap.WithMetrics[myKey, uint64]())
+
+	for i := 0; i < 100; i++ {
+		cm.Set(myKey(i), uint64(i))
+	}
+	cm.Get(myKey(0))
+	cm.Get(myKey(10_000)) // miss
+
+	var hits, misses, sets uint64
+	for i := range cm.shards {
+		snap := cm.shards[i].m.Metrics()
+		hits += snap.Hits
+		misses += snap.Misses
+		sets += snap.Sets
+	}
+	if sets != 100 {
+		t.Errorf("Sets across shards = %d; want 100", sets)
+	}
+	if hits == 0 || misses == 0 {
+		t.Errorf("hits=%d misses=%d; want both > 0, which requires WithMetrics to have reached the shards", hits, misses)
+	}
+}
+
+func TestRange(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5)
+	want := make(map[myKey]uint64)
+	for i := 0; i < 500; i++ {
+		cm.Set(myKey(i), uint64(i))
+		want[myKey(i)] = uint64(i)
+	}
+
+	got := make(map[myKey]uint64)
+	cm.Range(func(k myKey, v uint64) bool {
+		got[k] = v
+		return true
+	})
+	if len(got) != len(want) {
+		t.Fatalf("Range visited %d pairs; want %d", len(got), len(want))
+	}
+	for k, wantV := range want {
+		if gotV, ok := got[k]; !ok || gotV != wantV {
+			t.Errorf("Range pair for %v = (%d, %v); want %d", k, gotV, ok, wantV)
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	cm := New[myKey, uint64](0, 0.5)
+	const workers = 8
+	const perWorker = 2000
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				k := myKey(w*perWorker + i)
+				cm.Set(k, uint64(i))
+				if v, ok := cm.Get(k); !ok || v != uint64(i) {
+					t.Errorf("worker %d: Get(%v) = (%d, %v); want (%d, true)", w, k, v, ok, i)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if cm.Size() != workers*perWorker {
+		t.Fatalf("Size() = %d; want %d", cm.Size(), workers*perWorker)
+	}
+}
+
+var benchSizes = []int{1_000_000, 10_000_000}
+
+// BenchmarkMixed compares ConcurrentMap against sync.Map under a mixed
+// read/write workload, mirroring the lp-vs-map harness in lpmap_test.go.
+func BenchmarkMixed(b *testing.B) {
+	for _, size := range benchSizes {
+		cm := New[myKey, uint64](size, 0.5)
+		var sm sync.Map
+		for i := 0; i < size; i++ {
+			k := myKey(rand.Uint64())
+			cm.Set(k, uint64(i))
+			sm.Store(k, uint64(i))
+		}
+
+		b.ResetTimer()
+
+		b.Run(fmt.Sprintf("ConcurrentMap/%d", size), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					k := myKey(i)
+					if i%10 == 0 {
+						cm.Set(k, uint64(i))
+					} else {
+						cm.Get(k)
+					}
+					i++
+				}
+			})
+		})
+
+		b.Run(fmt.Sprintf("sync.Map/%d", size), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					k := myKey(i)
+					if i%10 == 0 {
+						sm.Store(k, uint64(i))
+					} else {
+						sm.Load(k)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
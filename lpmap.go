@@ -5,42 +5,338 @@ any type. Unlike other implementations, this version supports deletion of entrie
 
 Linear probing hash maps are generally faster than standard hash maps when the fill factor
 is set to 0.5 or less (this can result in increased memory usage), and when the number of
-entries is very large (in general, exceeding 1 million).
+entries is very large (in general, exceeding 1 million). Insertion uses Robin Hood hashing,
+which bounds the worst-case probe length by keeping the variance between probe sequences
+low, so this map can safely run at higher fill factors than plain linear probing.
 */
 package lpmap
 
+import (
+	"iter"
+	"math/rand"
+)
+
 type KeyType interface {
 	Hash() uint64
 	comparable
 }
 
-type status uint8
+const defaultFillFactor = 0.5
 
-const (
-	dead     status = 1
-	occupied status = 2
-)
+// migrationBudget is the number of occupied slots migrated from the old
+// table to the new one on each Set/Delete/Get, bounding the worst-case
+// latency of any single call while a resize is in progress.
+const migrationBudget = 4
 
-const defaultFillFactor = 0.5
+// migrationScanBudget bounds the number of old-table slots migrateStep will
+// examine per call, separately from migrationBudget. Deletes against old
+// (directly, or as a side effect of migrateStep relocating an entry) can
+// leave long runs of vacated slots ahead of oldCursor, so bounding only the
+// slots moved isn't enough: a call could still walk the entire length of
+// old looking for the next occupied one. Capping slots examined keeps that
+// walk bounded too, at the cost of migration taking more calls to finish
+// when old is sparse.
+const migrationScanBudget = 64
+
+// table is a single backing array set for a Map. A Map holds up to two
+// tables at once (cur and old) so that growth can be spread across many
+// calls instead of stalling one of them for O(n) work.
+//
+// Each slot records its displacement from its home bucket so Set can use
+// Robin Hood hashing (steal from the slot with the smaller displacement)
+// and Delete can use backward-shift deletion, which needs no tombstones.
+type table[K KeyType, V any] struct {
+	keys     []K
+	values   []V
+	occupied []bool
+	disp     []uint16
+}
+
+func newTable[K KeyType, V any](size int) *table[K, V] {
+	return &table[K, V]{
+		keys:     make([]K, size),
+		values:   make([]V, size),
+		occupied: make([]bool, size),
+		disp:     make([]uint16, size),
+	}
+}
+
+// find returns the index of k in t and the probe length (slots past k's
+// home bucket) at which the search settled, along with true if k is present. It stops as soon as
+// the probe distance exceeds the displacement of the slot being examined,
+// since Robin Hood hashing guarantees k would have displaced that
+// occupant were it present.
+func (t *table[K, V]) find(k K) (int, int, bool) {
+	n := len(t.keys)
+	if n == 0 {
+		return 0, 0, false
+	}
+	i := int(k.Hash() % uint64(n))
+	var probe uint16
+	for {
+		if !t.occupied[i] || t.disp[i] < probe {
+			return 0, int(probe), false
+		}
+		if t.keys[i] == k {
+			return i, int(probe), true
+		}
+		i++
+		if i == n {
+			i = 0
+		}
+		probe++
+	}
+}
+
+// set inserts or updates k/v in t, returning true if a new entry was added
+// (as opposed to an existing one being overwritten). Insertion follows
+// Robin Hood hashing: if the slot under examination has a smaller
+// displacement than the key being placed, they swap and the displaced
+// occupant continues probing in the inserting key's place.
+func (t *table[K, V]) set(k K, v V) bool {
+	n := len(t.keys)
+	i := int(k.Hash() % uint64(n))
+	var probe uint16
+	for {
+		if !t.occupied[i] {
+			t.keys[i] = k
+			t.values[i] = v
+			t.occupied[i] = true
+			t.disp[i] = probe
+			return true
+		}
+		if t.keys[i] == k {
+			t.values[i] = v
+			return false
+		}
+		if t.disp[i] < probe {
+			k, t.keys[i] = t.keys[i], k
+			v, t.values[i] = t.values[i], v
+			probe, t.disp[i] = t.disp[i], probe
+		}
+		i++
+		if i == n {
+			i = 0
+		}
+		probe++
+	}
+}
+
+// delete removes k from t using backward-shift deletion: entries that
+// follow in the same probe run are shifted back one slot (and their
+// displacement decremented) until an empty slot or a zero-displacement
+// entry is reached. This reclaims the slot immediately, so t never
+// accumulates tombstones.
+func (t *table[K, V]) delete(k K) bool {
+	i, _, found := t.find(k)
+	if !found {
+		return false
+	}
+	n := len(t.keys)
+	for {
+		next := i + 1
+		if next == n {
+			next = 0
+		}
+		if !t.occupied[next] || t.disp[next] == 0 {
+			var zeroK K
+			var zeroV V
+			t.keys[i] = zeroK
+			t.values[i] = zeroV
+			t.occupied[i] = false
+			t.disp[i] = 0
+			return true
+		}
+		t.keys[i] = t.keys[next]
+		t.values[i] = t.values[next]
+		t.disp[i] = t.disp[next] - 1
+		i = next
+	}
+}
+
+// metrics holds the optional counters for a Map with metrics collection
+// enabled. Map itself isn't safe for concurrent use: callers that need
+// that, such as the concurrent package, must supply their own
+// synchronization around every call that can touch a Map, metrics
+// included. Given that, plain counters are sufficient here without
+// atomics - the concurrent package's locking is what makes them safe, not
+// anything metrics does on its own.
+type metrics struct {
+	hits, misses  uint64
+	sets, updates uint64
+	deletes       uint64
+	resizes       uint64
+	maxProbeLen   uint64
+	probeLenHist  [8]uint64
+}
+
+// probeLenBucket maps a probe length (hops past the home bucket) to an
+// index into metrics.probeLenHist, bucketed by the number of slots
+// examined: <=1, <=2, <=4, <=8, <=16, <=32, <=64, >64.
+func probeLenBucket(probeLen int) int {
+	examined := probeLen + 1
+	switch {
+	case examined <= 1:
+		return 0
+	case examined <= 2:
+		return 1
+	case examined <= 4:
+		return 2
+	case examined <= 8:
+		return 3
+	case examined <= 16:
+		return 4
+	case examined <= 32:
+		return 5
+	case examined <= 64:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func (ms *metrics) recordGet(probeLen int, hit bool) {
+	if hit {
+		ms.hits++
+	} else {
+		ms.misses++
+	}
+	if uint64(probeLen) > ms.maxProbeLen {
+		ms.maxProbeLen = uint64(probeLen)
+	}
+	ms.probeLenHist[probeLenBucket(probeLen)]++
+}
+
+// MetricsSnapshot is a point-in-time copy of a Map's counters, returned by
+// Map.Metrics().
+type MetricsSnapshot struct {
+	Hits, Misses  uint64
+	Sets, Updates uint64
+	Deletes       uint64
+	Resizes       uint64
+	// TombstoneCount is always 0: backward-shift deletion reclaims a
+	// slot the moment a key is deleted, so a Map never carries
+	// tombstones. Kept so code written against tombstone-based maps
+	// doesn't need a special case.
+	TombstoneCount uint64
+	MaxProbeLen    uint64
+	// ProbeLenHist buckets Get probe lengths by slots examined: <=1,
+	// <=2, <=4, <=8, <=16, <=32, <=64, >64, in that order.
+	ProbeLenHist [8]uint64
+}
 
 type Map[K KeyType, V any] struct {
-	keys       []K
-	values     []V
-	statuses   []status
-	threshold  float64
-	numEntries int
+	cur             *table[K, V]
+	old             *table[K, V]
+	oldCursor       int
+	threshold       float64
+	numEntries      int
+	metrics         *metrics
+	shrinkOnCompact bool
+	autoCompact     bool
+}
+
+// Option configures optional behavior for a Map created via New.
+type Option[K KeyType, V any] func(*Map[K, V])
+
+// WithMetrics enables metrics collection on a Map created via New. Use
+// NewWithMetrics for the common case of wanting metrics from the start.
+func WithMetrics[K KeyType, V any]() Option[K, V] {
+	return func(m *Map[K, V]) {
+		m.metrics = &metrics{}
+	}
+}
+
+// WithShrinkOnCompact makes Compact rebuild the table at a size fitted to
+// the current entry count instead of preserving the existing capacity.
+func WithShrinkOnCompact[K KeyType, V any]() Option[K, V] {
+	return func(m *Map[K, V]) {
+		m.shrinkOnCompact = true
+	}
+}
+
+// autoCompactLoadFactor is the occupancy fraction of cur's capacity below
+// which WithAutoCompact triggers a Compact on Delete.
+const autoCompactLoadFactor = 0.25
+
+// WithAutoCompact makes Delete call Compact on its own once the map's
+// occupancy falls below 25% of its current capacity, implying
+// WithShrinkOnCompact so that capacity is actually given back.
+func WithAutoCompact[K KeyType, V any]() Option[K, V] {
+	return func(m *Map[K, V]) {
+		m.shrinkOnCompact = true
+		m.autoCompact = true
+	}
 }
 
 // New creates a new linear probing hash map with the given size and load factor.
-func New[K KeyType, V any](size int, fillFactor float64) Map[K, V] {
+func New[K KeyType, V any](size int, fillFactor float64, opts ...Option[K, V]) Map[K, V] {
 	if fillFactor <= 0 || fillFactor > 1 {
 		fillFactor = defaultFillFactor
 	}
 	nEntries := int(float64(size)/fillFactor) + 1
-	keys := make([]K, nEntries)
-	values := make([]V, nEntries)
-	statuses := make([]status, nEntries)
-	return Map[K, V]{keys, values, statuses, fillFactor, 0}
+	m := Map[K, V]{
+		cur:       newTable[K, V](nEntries),
+		threshold: fillFactor,
+	}
+	for _, opt := range opts {
+		opt(&m)
+	}
+	return m
+}
+
+// NewWithMetrics is equivalent to New with the WithMetrics option applied.
+func NewWithMetrics[K KeyType, V any](size int, fillFactor float64) Map[K, V] {
+	return New[K, V](size, fillFactor, WithMetrics[K, V]())
+}
+
+// migrateStep moves up to migrationBudget occupied slots from the old table
+// into the current one, rehashing each to its new position via the same
+// Robin Hood insertion Set uses, and examines at most migrationScanBudget
+// slots of old while looking for them. Entries are removed from the old
+// table with backward-shift deletion so the rest of its probe chains stay
+// intact for any migration work still to come. Once the old table has been
+// fully drained it is dropped.
+func (m *Map[K, V]) migrateStep() {
+	if m.old == nil {
+		return
+	}
+	moved, scanned := 0, 0
+	n := len(m.old.keys)
+	for moved < migrationBudget && scanned < migrationScanBudget && m.oldCursor < n {
+		i := m.oldCursor
+		if !m.old.occupied[i] {
+			m.oldCursor++
+			scanned++
+			continue
+		}
+		k, v := m.old.keys[i], m.old.values[i]
+		m.old.delete(k)
+		m.cur.set(k, v)
+		moved++
+		scanned++
+		// Do not advance oldCursor: backward-shift deletion may have
+		// moved another occupied entry into index i.
+	}
+	if m.oldCursor >= n {
+		m.old = nil
+		m.oldCursor = 0
+	}
+}
+
+// startMigration replaces cur with a freshly allocated table of newSize,
+// demoting the current table to old so its entries can be drained
+// incrementally instead of all at once.
+func (m *Map[K, V]) startMigration(newSize int) {
+	if newSize < m.numEntries+1 {
+		newSize = m.numEntries + 1
+	}
+	m.old = m.cur
+	m.oldCursor = 0
+	m.cur = newTable[K, V](newSize)
+	if m.metrics != nil {
+		m.metrics.resizes++
+	}
 }
 
 // Get returns a pointer to the value associated with the provided key along
@@ -49,106 +345,176 @@ func (m *Map[K, V]) Get(k K) (*V, bool) {
 	if m.numEntries == 0 {
 		return nil, false
 	}
-	i := int(k.Hash() % uint64(len(m.keys)))
-	var coll int
-	for {
-		status := m.statuses[i]
-		if status == occupied && m.keys[i] == k {
-			return &m.values[i], true
+	m.migrateStep()
+	i, probeLen, found := m.cur.find(k)
+	if found {
+		if m.metrics != nil {
+			m.metrics.recordGet(probeLen, true)
 		}
-		if status == 0 {
-			return nil, false
+		return &m.cur.values[i], true
+	}
+	if m.old != nil {
+		if oi, oProbeLen, oFound := m.old.find(k); oFound {
+			if m.metrics != nil {
+				m.metrics.recordGet(oProbeLen, true)
+			}
+			return &m.old.values[oi], true
 		}
-		i++
-		coll++
-		if i == len(m.keys) {
-			i = 0
+	}
+	if m.metrics != nil {
+		m.metrics.recordGet(probeLen, false)
+	}
+	return nil, false
+}
+
+// All returns an iterator over the key/value pairs in the map, in
+// unspecified order. Like the Go runtime map, iteration starts at a
+// randomized offset into the backing array so callers don't accidentally
+// depend on insertion order.
+//
+// Any incremental migration still in progress is completed before the
+// first pair is yielded, so iteration covers a single table.
+//
+// It is safe to Delete the key currently being visited during iteration.
+// A key Set during iteration may or may not be visited, depending on
+// where it lands relative to the iterator's current position.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for m.old != nil {
+			m.migrateStep()
 		}
+		rangeTable(m.cur, yield)
 	}
 }
 
-// Values returns a channel of values set in the map.
-func (m *Map[K, V]) Values() chan V {
-	ch := make(chan V, m.numEntries)
-	defer close(ch)
-	go func() {
-		for i, v := range m.values {
-			if m.statuses[i] == occupied {
-				ch <- v
+// Keys returns an iterator over the keys in the map. See All for ordering
+// and mutation-during-iteration guarantees.
+func (m *Map[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
 			}
 		}
-	}()
-	return ch
+	}
 }
 
-func getNextAvailableIndex[K KeyType](keys []K, statuses []status, k K) int {
-	i := int(k.Hash() % uint64(len(keys)))
-
-	for {
-		status := statuses[i]
-		if status != occupied {
-			return i
-		}
-		i++
-		if i == len(keys) {
-			i = 0
+// Values returns an iterator over the values in the map. See All for
+// ordering and mutation-during-iteration guarantees.
+func (m *Map[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.All() {
+			if !yield(v) {
+				return
+			}
 		}
 	}
 }
 
-func (m *Map[K, V]) resize(newSize int) {
+// Clone returns a copy of m holding the same key/value pairs.
+func (m *Map[K, V]) Clone() Map[K, V] {
+	clone := Map[K, V]{
+		cur:             cloneTable(m.cur),
+		threshold:       m.threshold,
+		numEntries:      m.numEntries,
+		shrinkOnCompact: m.shrinkOnCompact,
+		autoCompact:     m.autoCompact,
+	}
+	if m.old != nil {
+		clone.old = cloneTable(m.old)
+		clone.oldCursor = m.oldCursor
+	}
+	if m.metrics != nil {
+		cloneMetrics := *m.metrics
+		clone.metrics = &cloneMetrics
+	}
+	return clone
+}
 
-	if newSize < m.numEntries+1 {
-		newSize = m.numEntries + 1
+// AppendKeys appends the map's keys to dst and returns the extended slice,
+// for callers that want to avoid the allocation a fresh []K would require.
+func (m *Map[K, V]) AppendKeys(dst []K) []K {
+	for k := range m.Keys() {
+		dst = append(dst, k)
 	}
+	return dst
+}
 
-	newKeys := make([]K, newSize)
-	newValues := make([]V, newSize)
-	newStatuses := make([]status, newSize)
-	var count int
-	for i, k := range m.keys {
-		if m.statuses[i] == occupied {
-			newI := getNextAvailableIndex(newKeys, newStatuses, k)
-			newKeys[newI] = k
-			newValues[newI] = m.values[i]
-			newStatuses[newI] = occupied
-			count++
+// rangeTable visits the occupied slots of t starting at a randomized
+// offset, stopping early (and returning false) if yield does.
+//
+// Deleting the key just yielded can trigger backward-shift deletion, which
+// pulls a later, not-yet-visited entry back into the slot the scan just
+// left. A plain forward pass over the array would never reach that slot
+// again and silently skip the entry, so after each yield the slot is
+// rechecked: if it now holds a different key than the one just visited,
+// that's a shifted-in entry and it's visited in turn before the scan moves
+// on, instead of advancing past it.
+func rangeTable[K KeyType, V any](t *table[K, V], yield func(K, V) bool) bool {
+	n := len(t.keys)
+	if n == 0 {
+		return true
+	}
+	idx := rand.Intn(n)
+	for steps := 0; steps < n; steps++ {
+		if !t.occupied[idx] {
+			idx = (idx + 1) % n
+			continue
 		}
+		for {
+			k := t.keys[idx]
+			if !yield(k, t.values[idx]) {
+				return false
+			}
+			if t.occupied[idx] && t.keys[idx] != k {
+				continue
+			}
+			break
+		}
+		idx = (idx + 1) % n
 	}
-	newMap := Map[K, V]{
-		keys:       newKeys,
-		values:     newValues,
-		statuses:   newStatuses,
-		numEntries: count,
-		threshold:  m.threshold,
+	return true
+}
+
+func cloneTable[K KeyType, V any](t *table[K, V]) *table[K, V] {
+	clone := &table[K, V]{
+		keys:     make([]K, len(t.keys)),
+		values:   make([]V, len(t.values)),
+		occupied: make([]bool, len(t.occupied)),
+		disp:     make([]uint16, len(t.disp)),
 	}
-	*m = newMap
+	copy(clone.keys, t.keys)
+	copy(clone.values, t.values)
+	copy(clone.occupied, t.occupied)
+	copy(clone.disp, t.disp)
+	return clone
 }
 
 // Set inserts a key/value mapping into the hash map.
 func (m *Map[K, V]) Set(k K, v V) {
-	if float64(m.numEntries)+1 > float64(len(m.keys))*m.threshold {
-		m.resize(2 * len(m.keys))
+	m.migrateStep()
+	if m.old == nil && float64(m.numEntries)+1 > float64(len(m.cur.keys))*m.threshold {
+		m.startMigration(2 * len(m.cur.keys))
 	}
-	i := k.Hash() % uint64(len(m.keys))
-	for {
-		status := m.statuses[i]
-		if status != occupied {
-			m.keys[i] = k
-			m.values[i] = v
-			m.statuses[i] = occupied
-			m.numEntries++
-			return
-		}
-		if status == occupied {
-			if m.keys[i] == k {
-				m.values[i] = v
-				return
+	if m.old != nil {
+		if _, _, found := m.old.find(k); found {
+			m.old.delete(k)
+			m.cur.set(k, v)
+			if m.metrics != nil {
+				m.metrics.updates++
 			}
+			return
 		}
-		i++
-		if i == uint64(len(m.keys)) {
-			i = 0
+	}
+	added := m.cur.set(k, v)
+	if added {
+		m.numEntries++
+	}
+	if m.metrics != nil {
+		if added {
+			m.metrics.sets++
+		} else {
+			m.metrics.updates++
 		}
 	}
 }
@@ -159,25 +525,88 @@ func (m *Map[K, V]) Delete(k K) bool {
 	if m.numEntries == 0 {
 		return false
 	}
-	i := int(k.Hash() % uint64(len(m.keys)))
-	for {
-		status := m.statuses[i]
-		if status == occupied && m.keys[i] == k {
-			m.statuses[i] = dead
-			m.numEntries--
-			return true
-		}
-		if status == 0 {
-			return false
-		}
-		i++
-		if i == len(m.keys) {
-			i = 0
-		}
+	m.migrateStep()
+	deleted := m.cur.delete(k)
+	if !deleted && m.old != nil {
+		deleted = m.old.delete(k)
 	}
+	if !deleted {
+		return false
+	}
+	m.numEntries--
+	if m.metrics != nil {
+		m.metrics.deletes++
+	}
+	if m.autoCompact && m.old == nil && len(m.cur.keys) > 0 &&
+		float64(m.numEntries) < autoCompactLoadFactor*float64(len(m.cur.keys)) {
+		m.Compact()
+	}
+	return true
 }
 
 // Size returns the number of entries in the hash map.
 func (m *Map[K, V]) Size() int {
 	return m.numEntries
 }
+
+// LoadFactor returns the number of entries in the map as a fraction of the
+// current table's capacity. While a migration is in progress, the count
+// includes entries not yet moved out of the old table.
+func (m *Map[K, V]) LoadFactor() float64 {
+	if len(m.cur.keys) == 0 {
+		return 0
+	}
+	return float64(m.numEntries) / float64(len(m.cur.keys))
+}
+
+// TombstoneRatio returns the fraction of the current table's capacity
+// taken up by tombstones. Backward-shift deletion reclaims a slot the
+// moment a key is deleted, so a Map never carries tombstones and this
+// always returns 0; it exists so callers comparing lpmap against
+// tombstone-based schemes don't need a special case.
+func (m *Map[K, V]) TombstoneRatio() float64 {
+	return 0
+}
+
+// Metrics returns a snapshot of m's counters. It returns a zero-value
+// MetricsSnapshot if metrics collection was not enabled via WithMetrics
+// or NewWithMetrics.
+func (m *Map[K, V]) Metrics() MetricsSnapshot {
+	if m.metrics == nil {
+		return MetricsSnapshot{}
+	}
+	return MetricsSnapshot{
+		Hits:         m.metrics.hits,
+		Misses:       m.metrics.misses,
+		Sets:         m.metrics.sets,
+		Updates:      m.metrics.updates,
+		Deletes:      m.metrics.deletes,
+		Resizes:      m.metrics.resizes,
+		MaxProbeLen:  m.metrics.maxProbeLen,
+		ProbeLenHist: m.metrics.probeLenHist,
+	}
+}
+
+// Compact rebuilds the map's backing table in place, which also finishes
+// off any incremental migration still in progress. Because backward-shift
+// deletion reclaims a slot the instant a key is deleted, a Map never
+// accumulates tombstones the way a tombstone-based scheme would; Compact's
+// role here is to give back capacity that's gone unused since the table
+// last grew. By default it rebuilds at the current capacity; with
+// WithShrinkOnCompact it instead shrinks to fit numEntries.
+func (m *Map[K, V]) Compact() {
+	size := len(m.cur.keys)
+	if m.shrinkOnCompact {
+		size = int(float64(m.numEntries)/m.threshold) + 1
+	}
+	rebuilt := newTable[K, V](size)
+	for k, v := range m.All() {
+		rebuilt.set(k, v)
+	}
+	m.cur = rebuilt
+	m.old = nil
+	m.oldCursor = 0
+	if m.metrics != nil {
+		m.metrics.resizes++
+	}
+}
@@ -18,6 +18,18 @@ func (k myCollKey) Hash() uint64 {
 	return uint64(k) % 5
 }
 
+// uniqueKeys returns n distinct myKey values in random order, for tests
+// that need to Set a known number of keys without risking a collision
+// the way independent myKey(rand.Uint64()) draws can.
+func uniqueKeys(n int) []myKey {
+	keys := make([]myKey, n)
+	for i := range keys {
+		keys[i] = myKey(i)
+	}
+	rand.Shuffle(n, func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	return keys
+}
+
 var mapSizes = []int{10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
 var thresholds = []float64{0.5}
 
@@ -117,6 +129,424 @@ func TestDelete(t *testing.T) {
 	}
 
 }
+
+// TestMigration interleaves Set/Get/Delete while a resize-triggered
+// migration is in progress and checks that the map's externally visible
+// behavior (Size, Get, Delete) stays correct throughout the drain.
+func TestMigration(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	want := make(map[myKey]uint64)
+
+	// Grow the map past several resizes, interleaving reads, writes and
+	// deletes so that some operations land while m.old is still non-nil.
+	for i := 0; i < 2000; i++ {
+		k := myKey(i)
+		v := uint64(i)
+		lp.Set(k, v)
+		want[k] = v
+
+		if i%3 == 0 {
+			delK := myKey(i / 3)
+			if _, ok := want[delK]; ok {
+				lp.Delete(delK)
+				delete(want, delK)
+			}
+		}
+
+		if i%5 == 0 {
+			getK := myKey(i / 5)
+			wantV, wantOk := want[getK]
+			gotV, gotOk := lp.Get(getK)
+			if gotOk != wantOk {
+				t.Fatalf("Get(%v) found=%v; want %v", getK, gotOk, wantOk)
+			}
+			if wantOk && *gotV != wantV {
+				t.Fatalf("Get(%v) = %d; want %d", getK, *gotV, wantV)
+			}
+		}
+	}
+
+	if lp.Size() != len(want) {
+		t.Fatalf("Size() = %d; want %d", lp.Size(), len(want))
+	}
+	for k, wantV := range want {
+		gotV, ok := lp.Get(k)
+		if !ok {
+			t.Errorf("Get(%v) not found; want %d", k, wantV)
+			continue
+		}
+		if *gotV != wantV {
+			t.Errorf("Get(%v) = %d; want %d", k, *gotV, wantV)
+		}
+	}
+
+	// Drain any remaining migration by calling Get enough times, then
+	// confirm the old table has been fully released.
+	for i := 0; i < len(lp.cur.keys); i++ {
+		lp.Get(myKey(i))
+	}
+	if lp.old != nil {
+		t.Errorf("expected old table to be fully drained, got %d slots remaining", len(lp.old.keys)-lp.oldCursor)
+	}
+}
+
+// TestMigrateStepBoundsScan builds an old table that is almost entirely
+// vacated ahead of the migration cursor - the pattern left behind when
+// old-resident keys are deleted well before migrateStep reaches them - and
+// checks that a single migrateStep call doesn't walk the whole table
+// looking for the few occupied slots that remain.
+func TestMigrateStepBoundsScan(t *testing.T) {
+	const oldSize = 200_000
+	old := newTable[myKey, uint64](oldSize)
+	old.occupied[oldSize-2] = true
+	old.keys[oldSize-2] = myKey(1)
+	old.values[oldSize-2] = 100
+	old.occupied[oldSize-1] = true
+	old.keys[oldSize-1] = myKey(2)
+	old.values[oldSize-1] = 200
+
+	lp := New[myKey, uint64](0, 0.5)
+	lp.old = old
+	lp.oldCursor = 0
+	lp.numEntries = 2
+
+	lp.migrateStep()
+
+	if lp.oldCursor > migrationScanBudget {
+		t.Fatalf("migrateStep examined %d slots in one call; want <= %d", lp.oldCursor, migrationScanBudget)
+	}
+}
+
+// TestRobinHoodCollisions exercises heavy-collision insertion, lookup and
+// backward-shift deletion against myCollKey, where every key hashes into
+// one of five buckets.
+func TestRobinHoodCollisions(t *testing.T) {
+	const size = 200
+	lp := New[myCollKey, uint64](0, 0.9)
+	for i := 0; i < size; i++ {
+		lp.Set(myCollKey(i), uint64(i))
+	}
+	if lp.Size() != size {
+		t.Fatalf("Size() = %d; want %d", lp.Size(), size)
+	}
+	for i := 0; i < size; i++ {
+		v, ok := lp.Get(myCollKey(i))
+		if !ok || *v != uint64(i) {
+			t.Fatalf("Get(%d) = (%v, %v); want (%d, true)", i, v, ok, i)
+		}
+	}
+
+	// Delete every third key and confirm the rest are still reachable:
+	// backward-shift deletion must not strand entries that probed past
+	// the deleted slot.
+	deleted := make(map[myCollKey]bool)
+	for i := 0; i < size; i += 3 {
+		if !lp.Delete(myCollKey(i)) {
+			t.Fatalf("Delete(%d) = false; want true", i)
+		}
+		deleted[myCollKey(i)] = true
+	}
+	if lp.Size() != size-len(deleted) {
+		t.Fatalf("Size() = %d; want %d", lp.Size(), size-len(deleted))
+	}
+	for i := 0; i < size; i++ {
+		k := myCollKey(i)
+		v, ok := lp.Get(k)
+		if deleted[k] {
+			if ok {
+				t.Errorf("Get(%d) found deleted key", i)
+			}
+			continue
+		}
+		if !ok || *v != uint64(i) {
+			t.Errorf("Get(%d) = (%v, %v); want (%d, true)", i, v, ok, i)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	lp := NewWithMetrics[myKey, uint64](0, 0.5)
+	for i := 0; i < 100; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+	for i := 0; i < 100; i++ {
+		lp.Get(myKey(i))
+	}
+	for i := 100; i < 150; i++ {
+		lp.Get(myKey(i))
+	}
+	lp.Set(myKey(0), 999)
+	lp.Delete(myKey(1))
+
+	snap := lp.Metrics()
+	if snap.Sets != 100 {
+		t.Errorf("Sets = %d; want 100", snap.Sets)
+	}
+	if snap.Updates != 1 {
+		t.Errorf("Updates = %d; want 1", snap.Updates)
+	}
+	if snap.Deletes != 1 {
+		t.Errorf("Deletes = %d; want 1", snap.Deletes)
+	}
+	if snap.Hits != 100 {
+		t.Errorf("Hits = %d; want 100", snap.Hits)
+	}
+	if snap.Misses != 50 {
+		t.Errorf("Misses = %d; want 50", snap.Misses)
+	}
+	if snap.TombstoneCount != 0 {
+		t.Errorf("TombstoneCount = %d; want 0", snap.TombstoneCount)
+	}
+	var histSum uint64
+	for _, c := range snap.ProbeLenHist {
+		histSum += c
+	}
+	if histSum != snap.Hits+snap.Misses {
+		t.Errorf("ProbeLenHist sums to %d; want %d", histSum, snap.Hits+snap.Misses)
+	}
+}
+
+func TestMetricsDisabledByDefault(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	lp.Set(myKey(1), 1)
+	lp.Get(myKey(1))
+	if got := lp.Metrics(); got != (MetricsSnapshot{}) {
+		t.Errorf("Metrics() on a plain map = %+v; want the zero value", got)
+	}
+}
+
+func TestLoadFactorTombstoneRatio(t *testing.T) {
+	lp := New[myKey, uint64](100, 0.5)
+	for i := 0; i < 10; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+	if lf := lp.LoadFactor(); lf <= 0 || lf >= 1 {
+		t.Errorf("LoadFactor() = %f; want in (0, 1)", lf)
+	}
+	lp.Delete(myKey(0))
+	if tr := lp.TombstoneRatio(); tr != 0 {
+		t.Errorf("TombstoneRatio() = %f; want 0", tr)
+	}
+}
+
+func TestCompactBasic(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	keys := uniqueKeys(1000)
+	for i, k := range keys {
+		lp.Set(k, uint64(i))
+	}
+	capBefore := len(lp.cur.keys)
+
+	lp.Compact()
+	if len(lp.cur.keys) != capBefore {
+		t.Fatalf("Compact() without WithShrinkOnCompact changed capacity: %d -> %d", capBefore, len(lp.cur.keys))
+	}
+	if lp.Size() != 1000 {
+		t.Fatalf("Size() after Compact() = %d; want 1000", lp.Size())
+	}
+	for i, k := range keys {
+		v, ok := lp.Get(k)
+		if !ok || *v != uint64(i) {
+			t.Errorf("Get(%v) after Compact() = (%v, %v); want (%d, true)", k, v, ok, i)
+		}
+	}
+}
+
+func TestCompactShrink(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5, WithShrinkOnCompact[myKey, uint64]())
+	keys := uniqueKeys(1000)
+	for i, k := range keys {
+		lp.Set(k, uint64(i))
+	}
+	for i := 0; i < 800; i++ {
+		lp.Delete(keys[i])
+	}
+	capBefore := len(lp.cur.keys)
+
+	lp.Compact()
+	if len(lp.cur.keys) >= capBefore {
+		t.Fatalf("Compact() with WithShrinkOnCompact did not shrink: %d -> %d", capBefore, len(lp.cur.keys))
+	}
+	if lp.Size() != 200 {
+		t.Fatalf("Size() after Compact() = %d; want 200", lp.Size())
+	}
+	for i := 800; i < 1000; i++ {
+		v, ok := lp.Get(keys[i])
+		if !ok || *v != uint64(i) {
+			t.Errorf("Get(%v) after Compact() = (%v, %v); want (%d, true)", keys[i], v, ok, i)
+		}
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5, WithAutoCompact[myKey, uint64]())
+	keys := uniqueKeys(1000)
+	for i, k := range keys {
+		lp.Set(k, uint64(i))
+	}
+	capAfterInsert := len(lp.cur.keys)
+
+	for i := 0; i < 900; i++ {
+		lp.Delete(keys[i])
+	}
+	if len(lp.cur.keys) >= capAfterInsert {
+		t.Fatalf("expected WithAutoCompact to shrink capacity below %d, got %d", capAfterInsert, len(lp.cur.keys))
+	}
+	for i := 900; i < 1000; i++ {
+		v, ok := lp.Get(keys[i])
+		if !ok || *v != uint64(i) {
+			t.Errorf("Get(%v) after auto-compact = (%v, %v); want (%d, true)", keys[i], v, ok, i)
+		}
+	}
+}
+
+func TestAllKeysValues(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	want := make(map[myKey]uint64)
+	for i := 0; i < 200; i++ {
+		k, v := myKey(i), uint64(i*2)
+		lp.Set(k, v)
+		want[k] = v
+	}
+
+	got := make(map[myKey]uint64)
+	for k, v := range lp.All() {
+		got[k] = v
+	}
+	if len(got) != len(want) {
+		t.Fatalf("All() yielded %d pairs; want %d", len(got), len(want))
+	}
+	for k, wantV := range want {
+		if gotV, ok := got[k]; !ok || gotV != wantV {
+			t.Errorf("All() pair for %v = (%d, %v); want %d", k, gotV, ok, wantV)
+		}
+	}
+
+	var keys []myKey
+	for k := range lp.Keys() {
+		keys = append(keys, k)
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("Keys() yielded %d keys; want %d", len(keys), len(want))
+	}
+
+	var sum uint64
+	for v := range lp.Values() {
+		sum += v
+	}
+	var wantSum uint64
+	for _, v := range want {
+		wantSum += v
+	}
+	if sum != wantSum {
+		t.Errorf("Values() sum = %d; want %d", sum, wantSum)
+	}
+}
+
+func TestAllEarlyBreak(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	for i := 0; i < 50; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+	var seen int
+	for range lp.All() {
+		seen++
+		if seen == 5 {
+			break
+		}
+	}
+	if seen != 5 {
+		t.Errorf("expected early break after 5 pairs, saw %d", seen)
+	}
+}
+
+// TestAllMutateDuringIteration exercises the documented guarantee that
+// deleting the key currently being visited is safe mid-iteration.
+func TestAllMutateDuringIteration(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	for i := 0; i < 100; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+
+	var visited int
+	for k := range lp.Keys() {
+		visited++
+		lp.Delete(k)
+	}
+	if visited != 100 {
+		t.Errorf("expected to visit 100 keys, visited %d", visited)
+	}
+	if lp.Size() != 0 {
+		t.Errorf("Size() = %d after deleting every visited key; want 0", lp.Size())
+	}
+}
+
+// TestAllMutateDuringIterationWithCollisions repeats
+// TestAllMutateDuringIteration's visit-then-delete pattern with myCollKey,
+// whose heavy collisions produce long probe chains. Backward-shift
+// deletion of the key just yielded can pull a later, not-yet-visited
+// entry back into the slot rangeTable just left; without rangeTable
+// accounting for that, entries behind the cursor get silently skipped.
+func TestAllMutateDuringIterationWithCollisions(t *testing.T) {
+	lp := New[myCollKey, uint64](0, 0.9)
+	for i := 0; i < 200; i++ {
+		lp.Set(myCollKey(i), uint64(i))
+	}
+
+	var visited int
+	for k := range lp.Keys() {
+		visited++
+		lp.Delete(k)
+	}
+	if visited != 200 {
+		t.Errorf("expected to visit 200 keys, visited %d", visited)
+	}
+	if lp.Size() != 0 {
+		t.Errorf("Size() = %d after deleting every visited key; want 0", lp.Size())
+	}
+}
+
+func TestClone(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	for i := 0; i < 50; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+
+	clone := lp.Clone()
+	lp.Set(myKey(1000), 1000)
+	lp.Delete(myKey(0))
+
+	if clone.Size() != 50 {
+		t.Errorf("clone.Size() = %d; want 50", clone.Size())
+	}
+	if v, ok := clone.Get(myKey(0)); !ok || *v != 0 {
+		t.Errorf("clone.Get(0) = (%v, %v); want (0, true)", v, ok)
+	}
+	if _, ok := clone.Get(myKey(1000)); ok {
+		t.Errorf("clone should not see keys set on the original after Clone")
+	}
+}
+
+func TestAppendKeys(t *testing.T) {
+	lp := New[myKey, uint64](0, 0.5)
+	for i := 0; i < 20; i++ {
+		lp.Set(myKey(i), uint64(i))
+	}
+
+	dst := make([]myKey, 0, 20)
+	dst = lp.AppendKeys(dst)
+	if len(dst) != 20 {
+		t.Fatalf("AppendKeys returned %d keys; want 20", len(dst))
+	}
+	seen := make(map[myKey]bool)
+	for _, k := range dst {
+		seen[k] = true
+	}
+	if len(seen) != 20 {
+		t.Errorf("AppendKeys returned %d distinct keys; want 20", len(seen))
+	}
+}
+
 func BenchmarkGet(b *testing.B) {
 	for _, threshold := range thresholds {
 		for _, size := range mapSizes {
@@ -147,6 +577,40 @@ func BenchmarkGet(b *testing.B) {
 	}
 }
 
+// BenchmarkGetAfterDelete measures Get latency on a map after half of its
+// keys have been deleted, with and without an intervening Compact call.
+func BenchmarkGetAfterDelete(b *testing.B) {
+	for _, size := range mapSizes {
+		lp := New[myKey, uint64](0, 0.5)
+		survivors := make([]myKey, 0, size/2)
+		for i := 0; i < size; i++ {
+			k := myKey(rand.Uint64())
+			lp.Set(k, uint64(i))
+			if i%2 == 0 {
+				survivors = append(survivors, k)
+			} else {
+				lp.Delete(k)
+			}
+		}
+
+		compacted := lp.Clone()
+		compacted.Compact()
+
+		b.ResetTimer()
+
+		b.Run(fmt.Sprintf("Get/uncompacted/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lp.Get(survivors[i%len(survivors)])
+			}
+		})
+		b.Run(fmt.Sprintf("Get/compacted/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				compacted.Get(survivors[i%len(survivors)])
+			}
+		})
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	for _, threshold := range thresholds {
 		b.ResetTimer()